@@ -0,0 +1,283 @@
+package dns_cache
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// Resolver resolves a host to its addresses along with the minimum TTL
+// reported by the upstream DNS response, so callers can cache each record
+// for exactly as long as the authoritative server intended.
+type Resolver interface {
+	LookupHost(ctx context.Context, host string) (addrs []string, ttl time.Duration, err error)
+}
+
+// ResolveError reports a failure to resolve a particular host. Rcode holds
+// the DNS response code (e.g. dns.RcodeNameError for NXDOMAIN,
+// dns.RcodeServerFailure for SERVFAIL) when the failure came from a
+// well-formed reply rather than a transport error; it is left at
+// dns.RcodeSuccess otherwise.
+type ResolveError struct {
+	Host  string
+	Rcode int
+	Err   error
+}
+
+func (e *ResolveError) Error() string {
+	if e.Rcode != dns.RcodeSuccess {
+		return "dns_cache: lookup " + e.Host + ": " + dns.RcodeToString[e.Rcode] + ": " + e.Err.Error()
+	}
+
+	return "dns_cache: lookup " + e.Host + ": " + e.Err.Error()
+}
+
+func (e *ResolveError) Unwrap() error {
+	return e.Err
+}
+
+// SystemResolver resolves hostnames using the platform resolver - the same
+// behavior DnsCacheStorage falls back to when no Resolver is configured. It
+// never reports a TTL; callers should treat 0 as "use the fixed expiration".
+type SystemResolver struct{}
+
+// LookupHost implements Resolver.
+func (SystemResolver) LookupHost(ctx context.Context, host string) ([]string, time.Duration, error) {
+	addrs, err := net.DefaultResolver.LookupHost(ctx, host)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return addrs, 0, nil
+}
+
+// UDPTCPResolver resolves hostnames by querying a configurable list of
+// plain UDP/TCP DNS servers (host:port) directly via github.com/miekg/dns,
+// capturing the minimum TTL across the returned A/AAAA records.
+type UDPTCPResolver struct {
+	Servers []string
+	Client  *dns.Client
+}
+
+// NewUDPTCPResolver returns a UDPTCPResolver querying servers (host:port)
+// with the given per-query timeout.
+func NewUDPTCPResolver(servers []string, timeout time.Duration) *UDPTCPResolver {
+	return &UDPTCPResolver{
+		Servers: servers,
+		Client:  &dns.Client{Timeout: timeout},
+	}
+}
+
+// LookupHost implements Resolver.
+func (r *UDPTCPResolver) LookupHost(ctx context.Context, host string) ([]string, time.Duration, error) {
+	return exchangeAddrs(ctx, r.Client, r.Servers, host)
+}
+
+// DoTResolver resolves hostnames over DNS-over-TLS (RFC 7858).
+type DoTResolver struct {
+	Servers []string // host:port, e.g. "1.1.1.1:853"
+	Client  *dns.Client
+}
+
+// NewDoTResolver returns a DoTResolver querying servers over TLS.
+// A nil tlsConfig uses the Go defaults (including system root CAs).
+func NewDoTResolver(servers []string, tlsConfig *tls.Config, timeout time.Duration) *DoTResolver {
+	return &DoTResolver{
+		Servers: servers,
+		Client:  &dns.Client{Net: "tcp-tls", TLSConfig: tlsConfig, Timeout: timeout},
+	}
+}
+
+// LookupHost implements Resolver.
+func (r *DoTResolver) LookupHost(ctx context.Context, host string) ([]string, time.Duration, error) {
+	return exchangeAddrs(ctx, r.Client, r.Servers, host)
+}
+
+func exchangeAddrs(ctx context.Context, client *dns.Client, servers []string, host string) ([]string, time.Duration, error) {
+	if len(servers) == 0 {
+		return nil, 0, errors.New("dns_cache: no servers configured")
+	}
+
+	fqdn := dns.Fqdn(host)
+
+	var (
+		addrs     []string
+		minTTL    uint32
+		haveTTL   bool
+		lastRcode = dns.RcodeSuccess
+	)
+
+	for _, qtype := range []uint16{dns.TypeA, dns.TypeAAAA} {
+		msg := new(dns.Msg)
+		msg.SetQuestion(fqdn, qtype)
+		msg.RecursionDesired = true
+
+		var (
+			resp *dns.Msg
+			err  error
+		)
+
+		for _, server := range servers {
+			if err = ctx.Err(); err != nil {
+				return nil, 0, err
+			}
+
+			resp, _, err = client.Exchange(msg, server)
+			if err == nil {
+				break
+			}
+		}
+
+		if err != nil {
+			continue
+		}
+
+		if resp.Rcode != dns.RcodeSuccess {
+			lastRcode = resp.Rcode
+			continue
+		}
+
+		for _, rr := range resp.Answer {
+			addr := rrAddr(rr)
+			if addr == "" {
+				continue
+			}
+
+			addrs = append(addrs, addr)
+
+			if !haveTTL || rr.Header().Ttl < minTTL {
+				minTTL = rr.Header().Ttl
+				haveTTL = true
+			}
+		}
+	}
+
+	if len(addrs) == 0 {
+		return nil, 0, noAddrsError(host, lastRcode)
+	}
+
+	return addrs, time.Duration(minTTL) * time.Second, nil
+}
+
+// noAddrsError builds the ResolveError returned when a lookup produced no
+// addresses, surfacing the upstream's Rcode (NXDOMAIN, SERVFAIL, ...) when a
+// well-formed reply carried one instead of collapsing every failure into a
+// generic "not found".
+func noAddrsError(host string, rcode int) *ResolveError {
+	if rcode != dns.RcodeSuccess {
+		return &ResolveError{Host: host, Rcode: rcode, Err: errors.New("no A/AAAA records found")}
+	}
+
+	return &ResolveError{Host: host, Err: errors.New("no A/AAAA records found")}
+}
+
+func rrAddr(rr dns.RR) string {
+	switch rec := rr.(type) {
+	case *dns.A:
+		return rec.A.String()
+	case *dns.AAAA:
+		return rec.AAAA.String()
+	default:
+		return ""
+	}
+}
+
+// DoHResolver resolves hostnames over DNS-over-HTTPS (RFC 8484), sending
+// the DNS wire format as the body of a POST request.
+type DoHResolver struct {
+	Endpoint string // e.g. "https://dns.google/dns-query"
+	Client   *http.Client
+}
+
+// NewDoHResolver returns a DoHResolver querying endpoint with the given timeout.
+func NewDoHResolver(endpoint string, timeout time.Duration) *DoHResolver {
+	return &DoHResolver{
+		Endpoint: endpoint,
+		Client:   &http.Client{Timeout: timeout},
+	}
+}
+
+// LookupHost implements Resolver.
+func (r *DoHResolver) LookupHost(ctx context.Context, host string) ([]string, time.Duration, error) {
+	var (
+		addrs     []string
+		minTTL    uint32
+		haveTTL   bool
+		lastRcode = dns.RcodeSuccess
+	)
+
+	for _, qtype := range []uint16{dns.TypeA, dns.TypeAAAA} {
+		reply, err := r.exchange(ctx, host, qtype)
+		if err != nil {
+			continue
+		}
+
+		if reply.Rcode != dns.RcodeSuccess {
+			lastRcode = reply.Rcode
+			continue
+		}
+
+		for _, rr := range reply.Answer {
+			addr := rrAddr(rr)
+			if addr == "" {
+				continue
+			}
+
+			addrs = append(addrs, addr)
+
+			if !haveTTL || rr.Header().Ttl < minTTL {
+				minTTL = rr.Header().Ttl
+				haveTTL = true
+			}
+		}
+	}
+
+	if len(addrs) == 0 {
+		return nil, 0, noAddrsError(host, lastRcode)
+	}
+
+	return addrs, time.Duration(minTTL) * time.Second, nil
+}
+
+func (r *DoHResolver) exchange(ctx context.Context, host string, qtype uint16) (*dns.Msg, error) {
+	msg := new(dns.Msg)
+	msg.SetQuestion(dns.Fqdn(host), qtype)
+	msg.RecursionDesired = true
+
+	packed, err := msg.Pack()
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, r.Endpoint, bytes.NewReader(packed))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/dns-message")
+	req.Header.Set("Accept", "application/dns-message")
+
+	resp, err := r.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	reply := new(dns.Msg)
+	if err := reply.Unpack(body); err != nil {
+		return nil, err
+	}
+
+	return reply, nil
+}
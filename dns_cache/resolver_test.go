@@ -0,0 +1,209 @@
+package dns_cache_test
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/TykTechnologies/tyk/dns_cache"
+	"github.com/miekg/dns"
+)
+
+// dnsAHandler answers A queries for host with a single 60s-TTL record,
+// mirroring the reply TestDoHResolverLookupHost's mock server builds, so
+// the UDP/TCP and DoT resolver tests exercise the same merging/minTTL
+// logic through the actual wire protocol instead of HTTP.
+func dnsAHandler(t *testing.T) dns.HandlerFunc {
+	return func(w dns.ResponseWriter, r *dns.Msg) {
+		reply := new(dns.Msg)
+		reply.SetReply(r)
+
+		if len(r.Question) > 0 && r.Question[0].Qtype == dns.TypeA {
+			rr, err := dns.NewRR(host + " 60 IN A 127.0.0.1")
+			if err != nil {
+				t.Errorf("building A record: %v", err)
+				return
+			}
+			reply.Answer = append(reply.Answer, rr)
+		}
+
+		if err := w.WriteMsg(reply); err != nil {
+			t.Errorf("writing DNS reply: %v", err)
+		}
+	}
+}
+
+// generateSelfSignedCert returns an ephemeral self-signed certificate for
+// 127.0.0.1, valid for the lifetime of a single test's DoT server.
+func generateSelfSignedCert(t *testing.T) tls.Certificate {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generating test key: %v", err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "127.0.0.1"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		KeyUsage:     x509.KeyUsageKeyEncipherment | x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("creating test certificate: %v", err)
+	}
+
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func TestSystemResolverLookupHost(t *testing.T) {
+	tearDown := setupTestStorageFetchItem(&configTestStorageFetchItem{t, etcHostsMap, etcHostsErrorMap, nil})
+	defer tearDown()
+
+	resolver := dns_cache.SystemResolver{}
+
+	addrs, ttl, err := resolver.LookupHost(context.Background(), host)
+	if err != nil {
+		t.Fatalf("LookupHost(%q) failed: %v", host, err)
+	}
+
+	if ttl != 0 {
+		t.Fatalf("wanted SystemResolver to report no TTL, got %v", ttl)
+	}
+
+	if len(addrs) != len(etcHostsMap[host]) {
+		t.Fatalf("wanted %v, got %v", etcHostsMap[host], addrs)
+	}
+}
+
+func TestUDPTCPResolverNoServers(t *testing.T) {
+	resolver := dns_cache.NewUDPTCPResolver(nil, time.Second)
+
+	if _, _, err := resolver.LookupHost(context.Background(), host); err == nil {
+		t.Fatalf("wanted error when no servers are configured")
+	}
+}
+
+func TestUDPTCPResolverLookupHost(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listening for the test DNS server: %v", err)
+	}
+
+	server := &dns.Server{PacketConn: pc, Handler: dnsAHandler(t)}
+	go server.ActivateAndServe()
+	defer server.Shutdown()
+
+	resolver := dns_cache.NewUDPTCPResolver([]string{pc.LocalAddr().String()}, time.Second)
+
+	addrs, ttl, err := resolver.LookupHost(context.Background(), host)
+	if err != nil {
+		t.Fatalf("LookupHost(%q) failed: %v", host, err)
+	}
+
+	if ttl != 60*time.Second {
+		t.Fatalf("wanted ttl 60s, got %v", ttl)
+	}
+
+	if len(addrs) != 1 || addrs[0] != "127.0.0.1" {
+		t.Fatalf("wanted [127.0.0.1], got %v", addrs)
+	}
+}
+
+func TestDoTResolverLookupHost(t *testing.T) {
+	cert := generateSelfSignedCert(t)
+
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{cert}})
+	if err != nil {
+		t.Fatalf("listening for the test DoT server: %v", err)
+	}
+
+	server := &dns.Server{Listener: listener, Net: "tcp-tls", Handler: dnsAHandler(t)}
+	go server.ActivateAndServe()
+	defer server.Shutdown()
+
+	resolver := dns_cache.NewDoTResolver(
+		[]string{listener.Addr().String()},
+		&tls.Config{InsecureSkipVerify: true},
+		time.Second,
+	)
+
+	addrs, ttl, err := resolver.LookupHost(context.Background(), host)
+	if err != nil {
+		t.Fatalf("LookupHost(%q) failed: %v", host, err)
+	}
+
+	if ttl != 60*time.Second {
+		t.Fatalf("wanted ttl 60s, got %v", ttl)
+	}
+
+	if len(addrs) != 1 || addrs[0] != "127.0.0.1" {
+		t.Fatalf("wanted [127.0.0.1], got %v", addrs)
+	}
+}
+
+func TestDoHResolverLookupHost(t *testing.T) {
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		query := new(dns.Msg)
+		if err := query.Unpack(body); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		reply := new(dns.Msg)
+		reply.SetReply(query)
+
+		if query.Question[0].Qtype == dns.TypeA {
+			rr, err := dns.NewRR(host + " 60 IN A 127.0.0.1")
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			reply.Answer = append(reply.Answer, rr)
+		}
+
+		packed, err := reply.Pack()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/dns-message")
+		w.Write(packed)
+	}))
+	defer mockServer.Close()
+
+	resolver := dns_cache.NewDoHResolver(mockServer.URL, time.Second)
+
+	addrs, ttl, err := resolver.LookupHost(context.Background(), host)
+	if err != nil {
+		t.Fatalf("LookupHost(%q) failed: %v", host, err)
+	}
+
+	if ttl != 60*time.Second {
+		t.Fatalf("wanted ttl 60s, got %v", ttl)
+	}
+
+	if len(addrs) != 1 || addrs[0] != "127.0.0.1" {
+		t.Fatalf("wanted [127.0.0.1], got %v", addrs)
+	}
+}
@@ -0,0 +1,602 @@
+package dns_cache
+
+import (
+	"context"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	lru "github.com/hashicorp/golang-lru"
+)
+
+// DnsCacheItem holds the addresses cached for a single host, its expiry
+// time, and a running hit count used to drive prefetching decisions.
+type DnsCacheItem struct {
+	Addrs    []string
+	ExpireAt time.Time
+
+	hitCount *int64
+}
+
+func newDnsCacheItem(addrs []string, expireAt time.Time) DnsCacheItem {
+	var hits int64
+	return DnsCacheItem{Addrs: addrs, ExpireAt: expireAt, hitCount: &hits}
+}
+
+// IsExpired reports whether the item's TTL has elapsed.
+func (i DnsCacheItem) IsExpired() bool {
+	return time.Now().After(i.ExpireAt)
+}
+
+// IsEqualsTo reports whether the item caches exactly the given addresses, in order.
+func (i DnsCacheItem) IsEqualsTo(addrs []string) bool {
+	if len(i.Addrs) != len(addrs) {
+		return false
+	}
+
+	for idx := range i.Addrs {
+		if i.Addrs[idx] != addrs[idx] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// HitCount returns the number of times this record has been served from cache.
+func (i DnsCacheItem) HitCount() int64 {
+	if i.hitCount == nil {
+		return 0
+	}
+
+	return atomic.LoadInt64(i.hitCount)
+}
+
+func (i DnsCacheItem) bumpHitCount() {
+	if i.hitCount != nil {
+		atomic.AddInt64(i.hitCount, 1)
+	}
+}
+
+// PrefetchResolver re-resolves a host's current addresses. It is invoked by
+// the janitor in its own goroutine when a hot record is about to expire.
+type PrefetchResolver func(host string) ([]string, error)
+
+// DnsCacheStorage is an in-memory, TTL-based cache of resolved DNS hostnames.
+// It is safe for concurrent use.
+type DnsCacheStorage struct {
+	items sync.Map // string -> DnsCacheItem, used when capacity == 0
+
+	// lruItems backs the cache instead of items when a capacity was
+	// configured, evicting the least-recently-used entry once full.
+	lruItems *lru.Cache
+
+	expiration    time.Duration
+	checkInterval time.Duration
+
+	// ctx/cancel own the janitor's lifecycle: cancel is invoked by Close,
+	// and wg is used to wait for the janitor and any in-flight prefetch
+	// goroutines to exit before Close returns.
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	prefetchResolver     PrefetchResolver
+	prefetchHitThreshold int64
+	prefetchWindow       time.Duration
+	// prefetchInFlight marks hosts currently being refreshed, so repeated
+	// janitor ticks don't pile up duplicate requests against a resolver
+	// slower than checkInterval.
+	prefetchInFlight sync.Map // string -> struct{}
+	// prefetchedFor records the ExpireAt of the item a host's last prefetch
+	// stored, so later janitor ticks that find that same refreshed item
+	// inside prefetchWindow don't prefetch it again. It stops mattering the
+	// moment the item expires or is replaced with a genuinely new one.
+	prefetchedFor sync.Map // string -> time.Time
+
+	// resolver, when set, is used instead of net.LookupHost so that each
+	// record's TTL can be honored individually. MinTTL/MaxTTL clamp
+	// whatever TTL it reports.
+	resolver Resolver
+	MinTTL   time.Duration
+	MaxTTL   time.Duration
+
+	// negativeItems caches lookup failures (string -> negativeCacheEntry)
+	// for NegativeTTL, so a broken upstream isn't re-queried on every
+	// request. Disabled when NegativeTTL is zero.
+	negativeItems sync.Map
+	NegativeTTL   time.Duration
+
+	// OnPrefetch is called after a hot record has been refreshed in the background.
+	OnPrefetch func(host string, addrs []string)
+	// OnCacheHit is called whenever a lookup is served from cache.
+	OnCacheHit func(host string)
+	// OnCacheMiss is called whenever a lookup isn't found in cache and must be resolved.
+	OnCacheMiss func(host string)
+	// OnExpired is called when a record is removed because its TTL elapsed.
+	OnExpired func(host string, item DnsCacheItem)
+	// OnEvicted is called when a record is removed to make room under Capacity,
+	// and, in a capacity-bounded cache, for each unexpired record Clear removes.
+	OnEvicted func(host string, item DnsCacheItem)
+	// OnAfterPut is called after a record has been inserted or overwritten.
+	OnAfterPut func(host string, item DnsCacheItem)
+	// OnNegativeCacheHit is called whenever a lookup is served from the negative cache.
+	OnNegativeCacheHit func(host string)
+}
+
+type negativeCacheEntry struct {
+	err      error
+	expireAt time.Time
+}
+
+func (storage *DnsCacheStorage) setNegative(host string, err error) {
+	storage.negativeItems.Store(host, negativeCacheEntry{
+		err:      err,
+		expireAt: time.Now().Add(storage.NegativeTTL),
+	})
+}
+
+func (storage *DnsCacheStorage) loadNegative(host string) (error, bool) {
+	value, ok := storage.negativeItems.Load(host)
+	if !ok {
+		return nil, false
+	}
+
+	entry := value.(negativeCacheEntry)
+	if time.Now().After(entry.expireAt) {
+		storage.negativeItems.Delete(host)
+		return nil, false
+	}
+
+	return entry.err, true
+}
+
+// ClearNegative empties the negative-result cache.
+func (storage *DnsCacheStorage) ClearNegative() {
+	storage.negativeItems.Range(func(key, value interface{}) bool {
+		storage.negativeItems.Delete(key)
+		return true
+	})
+}
+
+// Option configures optional behavior on a DnsCacheStorage built via
+// NewDnsCacheStorageWithOptions.
+type Option func(*storageOptions)
+
+type storageOptions struct {
+	ctx                  context.Context
+	capacity             int
+	resolver             Resolver
+	minTTL               time.Duration
+	maxTTL               time.Duration
+	prefetchResolver     PrefetchResolver
+	prefetchHitThreshold int64
+	prefetchWindow       time.Duration
+}
+
+// WithContext makes the janitor goroutine also stop when ctx is done, in
+// addition to when Close is called.
+func WithContext(ctx context.Context) Option {
+	return func(o *storageOptions) { o.ctx = ctx }
+}
+
+// WithCapacity bounds the cache to capacity hosts, evicting the
+// least-recently-used entry (via Get/Set access) when full. Capacity-based
+// eviction runs independently of, and alongside, TTL-based cleanup.
+func WithCapacity(capacity int) Option {
+	return func(o *storageOptions) { o.capacity = capacity }
+}
+
+// WithResolver looks up addresses via resolver instead of net.LookupHost,
+// caching each record for the TTL the resolver reports rather than for a
+// fixed expiration. minTTL and maxTTL clamp that reported TTL; a zero maxTTL
+// means no upper bound. If resolver reports a zero TTL (as net.LookupHost
+// would if wrapped naively), expiration is used as a fallback.
+func WithResolver(resolver Resolver, minTTL, maxTTL time.Duration) Option {
+	return func(o *storageOptions) {
+		o.resolver = resolver
+		o.minTTL = minTTL
+		o.maxTTL = maxTTL
+	}
+}
+
+// WithPrefetch proactively refreshes records that have been hit at least
+// hitThreshold times via resolver once their remaining TTL drops below
+// prefetchWindow, instead of letting them be evicted on expiry. Records
+// under the threshold keep the regular eviction path. A record is refreshed
+// at most once: the refreshed copy is not prefetched again and is removed
+// when it expires.
+func WithPrefetch(resolver PrefetchResolver, hitThreshold int64, prefetchWindow time.Duration) Option {
+	return func(o *storageOptions) {
+		o.prefetchResolver = resolver
+		o.prefetchHitThreshold = hitThreshold
+		o.prefetchWindow = prefetchWindow
+	}
+}
+
+// NewDnsCacheStorage creates a DNS cache where entries expire after
+// expiration and are swept for removal every checkInterval. A non-positive
+// checkInterval disables the background cleanup goroutine, leaving expired
+// entries in place until they're overwritten or the cache is cleared.
+func NewDnsCacheStorage(expiration, checkInterval time.Duration) *DnsCacheStorage {
+	return NewDnsCacheStorageWithOptions(expiration, checkInterval)
+}
+
+// NewDnsCacheStorageWithContext is like NewDnsCacheStorage, but the janitor
+// goroutine also stops when ctx is done, in addition to when Close is
+// called.
+func NewDnsCacheStorageWithContext(ctx context.Context, expiration, checkInterval time.Duration) *DnsCacheStorage {
+	return NewDnsCacheStorageWithOptions(expiration, checkInterval, WithContext(ctx))
+}
+
+// NewDnsCacheStorageWithPrefetch is like NewDnsCacheStorage, but records that
+// have been hit at least hitThreshold times are proactively refreshed via
+// resolver once their remaining TTL drops below prefetchWindow, instead of
+// being evicted on expiry. Records under the threshold keep today's eviction
+// path. A record is refreshed at most once: the refreshed copy is not
+// prefetched again and is removed when it expires.
+func NewDnsCacheStorageWithPrefetch(expiration, checkInterval time.Duration, resolver PrefetchResolver, hitThreshold int64, prefetchWindow time.Duration) *DnsCacheStorage {
+	return NewDnsCacheStorageWithOptions(expiration, checkInterval, WithPrefetch(resolver, hitThreshold, prefetchWindow))
+}
+
+// NewDnsCacheStorageWithResolver is like NewDnsCacheStorage, but addresses
+// are looked up via resolver instead of net.LookupHost, and each record is
+// cached for the TTL the resolver reports rather than for a fixed
+// expiration. minTTL and maxTTL clamp that reported TTL; a zero maxTTL
+// means no upper bound. If resolver reports a zero TTL (as net.LookupHost
+// would if wrapped naively), expiration is used as a fallback.
+func NewDnsCacheStorageWithResolver(expiration, checkInterval time.Duration, resolver Resolver, minTTL, maxTTL time.Duration) *DnsCacheStorage {
+	return NewDnsCacheStorageWithOptions(expiration, checkInterval, WithResolver(resolver, minTTL, maxTTL))
+}
+
+// NewDnsCacheStorageWithCapacity is like NewDnsCacheStorage, but bounds the
+// cache to capacity hosts, evicting the least-recently-used entry (via
+// Get/Set access) when full. Capacity-based eviction runs independently of,
+// and alongside, TTL-based cleanup.
+func NewDnsCacheStorageWithCapacity(expiration, checkInterval time.Duration, capacity int) *DnsCacheStorage {
+	return NewDnsCacheStorageWithOptions(expiration, checkInterval, WithCapacity(capacity))
+}
+
+// NewDnsCacheStorageWithOptions creates a DNS cache where entries expire
+// after expiration and are swept for removal every checkInterval (a
+// non-positive checkInterval disables the background cleanup goroutine),
+// configured by any combination of Option values. It is the constructor the
+// NewDnsCacheStorageWith* helpers above are built on; prefer it directly
+// when a cache needs more than one of their behaviors at once.
+func NewDnsCacheStorageWithOptions(expiration, checkInterval time.Duration, opts ...Option) *DnsCacheStorage {
+	options := &storageOptions{ctx: context.Background()}
+	for _, opt := range opts {
+		opt(options)
+	}
+
+	ctx, cancel := context.WithCancel(options.ctx)
+
+	storage := &DnsCacheStorage{
+		expiration:           expiration,
+		checkInterval:        checkInterval,
+		ctx:                  ctx,
+		cancel:               cancel,
+		resolver:             options.resolver,
+		MinTTL:               options.minTTL,
+		MaxTTL:               options.maxTTL,
+		prefetchResolver:     options.prefetchResolver,
+		prefetchHitThreshold: options.prefetchHitThreshold,
+		prefetchWindow:       options.prefetchWindow,
+	}
+
+	// lruItems must be set before the janitor goroutine can possibly start,
+	// since cleanup (via rangeItems) reads it without synchronization.
+	if options.capacity > 0 {
+		lruItems, err := lru.NewWithEvict(options.capacity, func(key, value interface{}) {
+			storage.handleEviction(key.(string), value.(DnsCacheItem))
+		})
+		if err != nil {
+			panic(err)
+		}
+		storage.lruItems = lruItems
+	}
+
+	if checkInterval > 0 {
+		storage.wg.Add(1)
+		go storage.runCleanup()
+	}
+
+	return storage
+}
+
+// Close stops the janitor goroutine and waits for it, and any in-flight
+// prefetch goroutines, to exit. It is safe to call more than once.
+func (storage *DnsCacheStorage) Close() {
+	storage.cancel()
+	storage.wg.Wait()
+}
+
+// handleEviction is golang-lru's eviction callback, invoked synchronously
+// from Remove (our own TTL-driven expiry), Add (capacity-driven eviction of
+// some other, unrelated host) and Purge (Clear). It tells the two apart from the
+// item itself rather than from any call-site state, since removals for
+// different hosts can race each other.
+func (storage *DnsCacheStorage) handleEviction(host string, item DnsCacheItem) {
+	storage.prefetchedFor.Delete(host)
+
+	if item.IsExpired() {
+		if storage.OnExpired != nil {
+			storage.OnExpired(host, item)
+		}
+		return
+	}
+
+	if storage.OnEvicted != nil {
+		storage.OnEvicted(host, item)
+	}
+}
+
+func (storage *DnsCacheStorage) clampTTL(ttl time.Duration) time.Duration {
+	if ttl <= 0 {
+		return storage.expiration
+	}
+
+	if storage.MinTTL > 0 && ttl < storage.MinTTL {
+		ttl = storage.MinTTL
+	}
+
+	if storage.MaxTTL > 0 && ttl > storage.MaxTTL {
+		ttl = storage.MaxTTL
+	}
+
+	return ttl
+}
+
+func (storage *DnsCacheStorage) runCleanup() {
+	defer storage.wg.Done()
+
+	ticker := time.NewTicker(storage.checkInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-storage.ctx.Done():
+			return
+		case <-ticker.C:
+			storage.cleanup()
+		}
+	}
+}
+
+// loadItem, storeItem, deleteItem and rangeItems abstract over the two
+// possible backing stores: the unbounded sync.Map used by default, and the
+// capacity-bounded lruItems used when NewDnsCacheStorageWithCapacity
+// configured one.
+func (storage *DnsCacheStorage) loadItem(host string) (DnsCacheItem, bool) {
+	if storage.lruItems != nil {
+		value, ok := storage.lruItems.Get(host)
+		if !ok {
+			return DnsCacheItem{}, false
+		}
+		return value.(DnsCacheItem), true
+	}
+
+	value, ok := storage.items.Load(host)
+	if !ok {
+		return DnsCacheItem{}, false
+	}
+
+	return value.(DnsCacheItem), true
+}
+
+func (storage *DnsCacheStorage) storeItem(host string, item DnsCacheItem) {
+	if storage.lruItems != nil {
+		storage.lruItems.Add(host, item)
+	} else {
+		storage.items.Store(host, item)
+	}
+
+	if storage.OnAfterPut != nil {
+		storage.OnAfterPut(host, item)
+	}
+}
+
+func (storage *DnsCacheStorage) deleteItem(host string) {
+	if storage.lruItems != nil {
+		storage.lruItems.Remove(host)
+		return
+	}
+
+	storage.items.Delete(host)
+}
+
+func (storage *DnsCacheStorage) rangeItems(f func(host string, item DnsCacheItem) bool) {
+	if storage.lruItems != nil {
+		for _, key := range storage.lruItems.Keys() {
+			host := key.(string)
+
+			value, ok := storage.lruItems.Peek(host)
+			if !ok {
+				continue
+			}
+
+			if !f(host, value.(DnsCacheItem)) {
+				return
+			}
+		}
+		return
+	}
+
+	storage.items.Range(func(key, value interface{}) bool {
+		return f(key.(string), value.(DnsCacheItem))
+	})
+}
+
+func (storage *DnsCacheStorage) cleanup() {
+	now := time.Now()
+
+	var expiredHosts []string
+
+	storage.rangeItems(func(host string, item DnsCacheItem) bool {
+		remaining := item.ExpireAt.Sub(now)
+
+		if storage.prefetchResolver != nil && remaining > 0 && remaining <= storage.prefetchWindow && item.HitCount() >= storage.prefetchHitThreshold {
+			if prefetchedFor, ok := storage.prefetchedFor.Load(host); ok && prefetchedFor.(time.Time).Equal(item.ExpireAt) {
+				return true
+			}
+
+			if _, inFlight := storage.prefetchInFlight.LoadOrStore(host, struct{}{}); !inFlight {
+				storage.wg.Add(1)
+				go func(host string, item DnsCacheItem) {
+					defer storage.wg.Done()
+					defer storage.prefetchInFlight.Delete(host)
+					storage.prefetch(host, item)
+				}(host, item)
+			}
+			return true
+		}
+
+		if now.After(item.ExpireAt) {
+			expiredHosts = append(expiredHosts, host)
+		}
+
+		return true
+	})
+
+	for _, host := range expiredHosts {
+		storage.expireItem(host)
+	}
+}
+
+func (storage *DnsCacheStorage) expireItem(host string) {
+	item, ok := storage.loadItem(host)
+	if !ok || !item.IsExpired() {
+		return
+	}
+
+	storage.deleteItem(host)
+	storage.prefetchedFor.Delete(host)
+
+	// The lru backing store already fired OnExpired via handleEviction.
+	if storage.lruItems == nil && storage.OnExpired != nil {
+		storage.OnExpired(host, item)
+	}
+}
+
+func (storage *DnsCacheStorage) prefetch(host string, old DnsCacheItem) {
+	addrs, err := storage.prefetchResolver(host)
+	if err != nil {
+		return
+	}
+
+	refreshed := DnsCacheItem{
+		Addrs:    addrs,
+		ExpireAt: time.Now().Add(storage.expiration),
+		hitCount: old.hitCount,
+	}
+	storage.storeItem(host, refreshed)
+	storage.prefetchedFor.Store(host, refreshed.ExpireAt)
+
+	if storage.OnPrefetch != nil {
+		storage.OnPrefetch(host, addrs)
+	}
+}
+
+// Set stores addrs for host, overwriting any existing entry and resetting its
+// expiry to the storage's fixed expiration.
+func (storage *DnsCacheStorage) Set(host string, addrs []string) {
+	storage.setWithTTL(host, addrs, storage.expiration)
+}
+
+func (storage *DnsCacheStorage) setWithTTL(host string, addrs []string, ttl time.Duration) {
+	storage.storeItem(host, newDnsCacheItem(addrs, time.Now().Add(ttl)))
+}
+
+// Get returns the cached item for host, if present.
+func (storage *DnsCacheStorage) Get(host string) (DnsCacheItem, bool) {
+	item, ok := storage.loadItem(host)
+	if !ok {
+		return DnsCacheItem{}, false
+	}
+
+	item.bumpHitCount()
+
+	return item, true
+}
+
+// Items returns a snapshot of the cache. When includeExpired is false,
+// entries whose TTL has elapsed are omitted.
+func (storage *DnsCacheStorage) Items(includeExpired bool) map[string]DnsCacheItem {
+	items := make(map[string]DnsCacheItem)
+
+	storage.rangeItems(func(host string, item DnsCacheItem) bool {
+		if includeExpired || !item.IsExpired() {
+			items[host] = item
+		}
+		return true
+	})
+
+	return items
+}
+
+// Clear empties the cache. In a capacity-bounded cache it reports each
+// removed record through OnExpired or OnEvicted; otherwise it calls neither.
+func (storage *DnsCacheStorage) Clear() {
+	if storage.lruItems != nil {
+		storage.lruItems.Purge()
+		return
+	}
+
+	storage.items.Range(func(key, value interface{}) bool {
+		storage.items.Delete(key)
+		return true
+	})
+}
+
+// FetchItem returns the addresses for host, serving from cache when
+// possible and falling back to the configured Resolver (or net.LookupHost
+// when none is set) otherwise.
+func (storage *DnsCacheStorage) FetchItem(host string) ([]string, error) {
+	if item, ok := storage.Get(host); ok {
+		if storage.OnCacheHit != nil {
+			storage.OnCacheHit(host)
+		}
+		return item.Addrs, nil
+	}
+
+	if storage.NegativeTTL > 0 {
+		if negErr, ok := storage.loadNegative(host); ok {
+			if storage.OnNegativeCacheHit != nil {
+				storage.OnNegativeCacheHit(host)
+			}
+			return nil, negErr
+		}
+	}
+
+	if storage.OnCacheMiss != nil {
+		storage.OnCacheMiss(host)
+	}
+
+	var (
+		addrs []string
+		err   error
+	)
+
+	if storage.resolver != nil {
+		var ttl time.Duration
+		addrs, ttl, err = storage.resolver.LookupHost(storage.ctx, host)
+		if err == nil {
+			storage.setWithTTL(host, addrs, storage.clampTTL(ttl))
+		}
+	} else {
+		addrs, err = net.LookupHost(host)
+		if err == nil {
+			storage.Set(host, addrs)
+		}
+	}
+
+	if err != nil {
+		if storage.NegativeTTL > 0 {
+			storage.setNegative(host, err)
+		}
+		return nil, err
+	}
+
+	return addrs, nil
+}
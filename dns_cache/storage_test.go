@@ -1,15 +1,19 @@
 package dns_cache_test
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"net"
 	"reflect"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/TykTechnologies/tyk/dns_cache"
 	"github.com/TykTechnologies/tyk/test"
 	"github.com/miekg/dns"
+	"go.uber.org/goleak"
 )
 
 var (
@@ -66,7 +70,7 @@ func TestStorageFetchItem(t *testing.T) {
 	tearDownTestStorageFetchItem := setupTestStorageFetchItem(&configTestStorageFetchItem{t, etcHostsMap, etcHostsErrorMap, dnsCache})
 	defer func() {
 		tearDownTestStorageFetchItem()
-		dnsCache.Clear()
+		dnsCache.Close()
 		dnsCache = nil
 	}()
 
@@ -265,8 +269,300 @@ func TestStorageRecordExpiration(t *testing.T) {
 				}
 			}
 
-			dnsCache.Clear()
+			dnsCache.Close()
 			dnsCache = nil
 		})
 	}
 }
+
+func TestStoragePrefetch(t *testing.T) {
+	var prefetched int32
+
+	resolver := func(h string) ([]string, error) {
+		atomic.AddInt32(&prefetched, 1)
+		return etcHostsMap[host], nil
+	}
+
+	dnsCache := dns_cache.NewDnsCacheStorageWithPrefetch(
+		150*time.Millisecond, 50*time.Millisecond,
+		resolver, 1, 120*time.Millisecond,
+	)
+	defer dnsCache.Close()
+
+	dnsCache.Set(host, etcHostsMap[host])
+
+	// First Get bumps the hit count past the threshold so the janitor
+	// refreshes the record instead of evicting it once it nears expiry.
+	if _, ok := dnsCache.Get(host); !ok {
+		t.Fatalf("expected %q to be cached", host)
+	}
+
+	time.Sleep(200 * time.Millisecond)
+
+	if atomic.LoadInt32(&prefetched) == 0 {
+		t.Fatalf("wanted record to be prefetched instead of evicted")
+	}
+
+	if _, ok := dnsCache.Get(host); !ok {
+		t.Fatalf("wanted prefetched record to still be cached")
+	}
+}
+
+func TestStoragePrefetchNoDuplicateInFlight(t *testing.T) {
+	var (
+		prefetched  int32
+		resolveGate = make(chan struct{})
+	)
+
+	resolver := func(h string) ([]string, error) {
+		atomic.AddInt32(&prefetched, 1)
+		<-resolveGate
+		return etcHostsMap[host], nil
+	}
+
+	// checkInterval ticks several times over per host while the resolver
+	// above is still blocked on the first call.
+	dnsCache := dns_cache.NewDnsCacheStorageWithPrefetch(
+		150*time.Millisecond, 20*time.Millisecond,
+		resolver, 1, 140*time.Millisecond,
+	)
+	defer dnsCache.Close()
+
+	dnsCache.Set(host, etcHostsMap[host])
+	if _, ok := dnsCache.Get(host); !ok {
+		t.Fatalf("expected %q to be cached", host)
+	}
+
+	time.Sleep(120 * time.Millisecond)
+	close(resolveGate)
+	time.Sleep(40 * time.Millisecond)
+
+	if got := atomic.LoadInt32(&prefetched); got != 1 {
+		t.Fatalf("wanted exactly 1 in-flight prefetch call for %q, got %d", host, got)
+	}
+}
+
+type fakeResolver struct {
+	addrs map[string][]string
+	ttls  map[string]time.Duration
+}
+
+func (r *fakeResolver) LookupHost(_ context.Context, h string) ([]string, time.Duration, error) {
+	return r.addrs[h], r.ttls[h], nil
+}
+
+func TestStoragePerRecordTTL(t *testing.T) {
+	resolver := &fakeResolver{
+		addrs: map[string][]string{
+			host:  etcHostsMap[host],
+			host2: etcHostsMap[host2],
+			host3: etcHostsMap[host3],
+		},
+		ttls: map[string]time.Duration{
+			host:  50 * time.Millisecond,  // below MinTTL, gets clamped up
+			host2: 5 * time.Second,        // above MaxTTL, gets clamped down
+			host3: 150 * time.Millisecond, // within bounds, used as-is
+		},
+	}
+
+	dnsCache := dns_cache.NewDnsCacheStorageWithResolver(
+		1*time.Second, 50*time.Millisecond,
+		resolver, 100*time.Millisecond, 200*time.Millisecond,
+	)
+	defer dnsCache.Close()
+
+	for _, h := range []string{host, host2, host3} {
+		if _, err := dnsCache.FetchItem(h); err != nil {
+			t.Fatalf("FetchItem(%q) failed: %v", h, err)
+		}
+	}
+
+	// host's 50ms TTL is clamped up to the 100ms MinTTL, so it should
+	// still be cached just after its unclamped TTL would have elapsed.
+	time.Sleep(80 * time.Millisecond)
+	if _, ok := dnsCache.Get(host); !ok {
+		t.Fatalf("wanted %q clamped up to MinTTL to still be cached", host)
+	}
+
+	// host2's 5s TTL is clamped down to the 200ms MaxTTL.
+	time.Sleep(200 * time.Millisecond)
+	if _, ok := dnsCache.Items(false)[host2]; ok {
+		t.Fatalf("wanted %q clamped down to MaxTTL to have expired", host2)
+	}
+}
+
+func TestStorageCapacityEviction(t *testing.T) {
+	dnsCache := dns_cache.NewDnsCacheStorageWithCapacity(time.Minute, -1, 2)
+	defer dnsCache.Close()
+
+	var evictedHosts []string
+	var afterPutCount int32
+
+	dnsCache.OnEvicted = func(h string, _ dns_cache.DnsCacheItem) {
+		evictedHosts = append(evictedHosts, h)
+	}
+	dnsCache.OnAfterPut = func(string, dns_cache.DnsCacheItem) {
+		atomic.AddInt32(&afterPutCount, 1)
+	}
+
+	dnsCache.Set(host, etcHostsMap[host])
+	dnsCache.Set(host2, etcHostsMap[host2])
+
+	// Touch host so it's more recently used than host2.
+	if _, ok := dnsCache.Get(host); !ok {
+		t.Fatalf("expected %q to be cached", host)
+	}
+
+	// Adding a third entry over capacity should evict host2, the LRU entry.
+	dnsCache.Set(host3, etcHostsMap[host3])
+
+	if len(evictedHosts) != 1 || evictedHosts[0] != host2 {
+		t.Fatalf("wanted %q evicted, got %v", host2, evictedHosts)
+	}
+
+	if _, ok := dnsCache.Get(host2); ok {
+		t.Fatalf("wanted %q to have been evicted", host2)
+	}
+
+	if _, ok := dnsCache.Get(host); !ok {
+		t.Fatalf("wanted %q to still be cached", host)
+	}
+
+	if got := atomic.LoadInt32(&afterPutCount); got != 3 {
+		t.Fatalf("wanted OnAfterPut called 3 times, got %d", got)
+	}
+}
+
+func TestStorageWithOptionsCombined(t *testing.T) {
+	resolver := &fakeResolver{
+		addrs: map[string][]string{host: etcHostsMap[host], host2: etcHostsMap[host2]},
+		ttls:  map[string]time.Duration{host: time.Minute, host2: time.Minute},
+	}
+
+	dnsCache := dns_cache.NewDnsCacheStorageWithOptions(
+		time.Minute, -1,
+		dns_cache.WithCapacity(1),
+		dns_cache.WithResolver(resolver, 0, 0),
+	)
+	defer dnsCache.Close()
+
+	var evictedHosts []string
+	dnsCache.OnEvicted = func(h string, _ dns_cache.DnsCacheItem) {
+		evictedHosts = append(evictedHosts, h)
+	}
+
+	if _, err := dnsCache.FetchItem(host); err != nil {
+		t.Fatalf("FetchItem(%q) failed: %v", host, err)
+	}
+	if _, err := dnsCache.FetchItem(host2); err != nil {
+		t.Fatalf("FetchItem(%q) failed: %v", host2, err)
+	}
+
+	if len(evictedHosts) != 1 || evictedHosts[0] != host {
+		t.Fatalf("wanted %q evicted once capacity 1 was exceeded, got %v", host, evictedHosts)
+	}
+}
+
+func TestStorageCloseStopsJanitor(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	dnsCache := dns_cache.NewDnsCacheStorageWithContext(ctx, time.Second, 10*time.Millisecond)
+	dnsCache.Close()
+
+	// Close must return once the janitor goroutine has actually exited, so
+	// a second Close (as defer-heavy callers tend to trigger) must not hang.
+	dnsCache.Close()
+}
+
+func TestStorageNegativeCache(t *testing.T) {
+	dnsCache := dns_cache.NewDnsCacheStorage(time.Duration(expiration)*time.Second, -1)
+	dnsCache.NegativeTTL = 100 * time.Millisecond
+
+	tearDown := setupTestStorageFetchItem(&configTestStorageFetchItem{t, etcHostsMap, etcHostsErrorMap, dnsCache})
+	defer func() {
+		tearDown()
+		dnsCache.Close()
+	}()
+
+	var misses, negativeHits int32
+	dnsCache.OnCacheMiss = func(string) { atomic.AddInt32(&misses, 1) }
+	dnsCache.OnNegativeCacheHit = func(string) { atomic.AddInt32(&negativeHits, 1) }
+
+	if _, err := dnsCache.FetchItem(hostErrorable); err == nil {
+		t.Fatalf("expected lookup error for %q", hostErrorable)
+	}
+	if got := atomic.LoadInt32(&misses); got != 1 {
+		t.Fatalf("wanted 1 cache miss, got %d", got)
+	}
+
+	// Within the negative TTL window the resolver isn't re-queried.
+	if _, err := dnsCache.FetchItem(hostErrorable); err == nil {
+		t.Fatalf("expected cached lookup error for %q", hostErrorable)
+	}
+	if got := atomic.LoadInt32(&misses); got != 1 {
+		t.Fatalf("wanted no additional cache miss within negative TTL, got %d misses", got)
+	}
+	if got := atomic.LoadInt32(&negativeHits); got != 1 {
+		t.Fatalf("wanted 1 negative cache hit, got %d", got)
+	}
+
+	time.Sleep(150 * time.Millisecond)
+
+	if _, err := dnsCache.FetchItem(hostErrorable); err == nil {
+		t.Fatalf("expected lookup error for %q after negative TTL elapsed", hostErrorable)
+	}
+	if got := atomic.LoadInt32(&misses); got != 2 {
+		t.Fatalf("wanted host to be re-queried after negative TTL elapsed, got %d misses", got)
+	}
+}
+
+type errResolver struct {
+	err error
+}
+
+func (r *errResolver) LookupHost(_ context.Context, _ string) ([]string, time.Duration, error) {
+	return nil, 0, r.err
+}
+
+func TestStorageNegativeCacheResolverError(t *testing.T) {
+	lookupErr := &dns_cache.ResolveError{Host: hostErrorable, Rcode: dns.RcodeNameError, Err: errors.New("no A/AAAA records found")}
+	resolver := &errResolver{err: lookupErr}
+
+	dnsCache := dns_cache.NewDnsCacheStorageWithResolver(
+		time.Duration(expiration)*time.Second, -1,
+		resolver, 0, 0,
+	)
+	dnsCache.NegativeTTL = 100 * time.Millisecond
+	defer dnsCache.Close()
+
+	var misses int32
+	dnsCache.OnCacheMiss = func(string) { atomic.AddInt32(&misses, 1) }
+
+	if _, err := dnsCache.FetchItem(hostErrorable); !errors.Is(err, lookupErr) {
+		t.Fatalf("wanted the resolver's *ResolveError back, got %v", err)
+	}
+
+	// A non-*net.DNSError failure must still populate the negative cache.
+	if _, err := dnsCache.FetchItem(hostErrorable); !errors.Is(err, lookupErr) {
+		t.Fatalf("wanted the cached *ResolveError back, got %v", err)
+	}
+	if got := atomic.LoadInt32(&misses); got != 1 {
+		t.Fatalf("wanted no additional cache miss within negative TTL, got %d misses", got)
+	}
+}
+
+func TestStorageNoGoroutineLeak(t *testing.T) {
+	// Snapshot goroutines already running (e.g. the DNS mock's listener) so
+	// only goroutines newly leaked by the construct/destroy cycles below
+	// are flagged, not pre-existing ones outside this test's control.
+	opts := goleak.IgnoreCurrent()
+
+	for i := 0; i < 100; i++ {
+		dnsCache := dns_cache.NewDnsCacheStorage(50*time.Millisecond, 5*time.Millisecond)
+		dnsCache.Set(host, etcHostsMap[host])
+		dnsCache.Close()
+	}
+
+	goleak.VerifyNone(t, opts...)
+}